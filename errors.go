@@ -0,0 +1,155 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// Sentinel errors for each of the built-in error types. A downstream
+// package that wraps its own error type and implements Is(target error)
+// bool against one of these can participate in ErrorToCode, trail.ToGRPC
+// and the IsX predicates below without having to embed the corresponding
+// trace.*Error.
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrAlreadyExists     = errors.New("already exists")
+	ErrAccessDenied      = errors.New("access denied")
+	ErrBadParameter      = errors.New("bad parameter")
+	ErrCompareFailed     = errors.New("compare failed")
+	ErrConnectionProblem = errors.New("connection problem")
+	ErrLimitExceeded     = errors.New("limit exceeded")
+	ErrNotImplemented    = errors.New("not implemented")
+	ErrOAuth2            = errors.New("oauth2 error")
+	ErrUnauthenticated   = errors.New("unauthenticated")
+	ErrCanceled          = errors.New("canceled")
+	ErrDeadlineExceeded  = errors.New("deadline exceeded")
+)
+
+// Is reports whether target is ErrNotFound, so errors.Is(err, ErrNotFound)
+// matches a *NotFoundError without a type assertion.
+func (*NotFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// Is reports whether target is ErrAlreadyExists.
+func (*AlreadyExistsError) Is(target error) bool { return target == ErrAlreadyExists }
+
+// Is reports whether target is ErrAccessDenied.
+func (*AccessDeniedError) Is(target error) bool { return target == ErrAccessDenied }
+
+// Is reports whether target is ErrBadParameter.
+func (*BadParameterError) Is(target error) bool { return target == ErrBadParameter }
+
+// Is reports whether target is ErrCompareFailed.
+func (*CompareFailedError) Is(target error) bool { return target == ErrCompareFailed }
+
+// Is reports whether target is ErrConnectionProblem.
+func (*ConnectionProblemError) Is(target error) bool { return target == ErrConnectionProblem }
+
+// Is reports whether target is ErrLimitExceeded.
+func (*LimitExceededError) Is(target error) bool { return target == ErrLimitExceeded }
+
+// Is reports whether target is ErrNotImplemented.
+func (*NotImplementedError) Is(target error) bool { return target == ErrNotImplemented }
+
+// Is reports whether target is ErrOAuth2.
+func (*OAuth2Error) Is(target error) bool { return target == ErrOAuth2 }
+
+// UnauthenticatedError indicates that the request lacked valid
+// authentication credentials.
+type UnauthenticatedError struct {
+	Message string `json:"message"`
+}
+
+func (e *UnauthenticatedError) Error() string { return e.Message }
+
+// Is reports whether target is ErrUnauthenticated.
+func (*UnauthenticatedError) Is(target error) bool { return target == ErrUnauthenticated }
+
+// CanceledError indicates that the operation was canceled, typically
+// because the context carrying it was canceled.
+type CanceledError struct {
+	Message string `json:"message"`
+}
+
+func (e *CanceledError) Error() string { return e.Message }
+
+// Is reports whether target is ErrCanceled.
+func (*CanceledError) Is(target error) bool { return target == ErrCanceled }
+
+// DeadlineExceededError indicates that the operation did not complete
+// before its deadline, typically because the context carrying it expired.
+type DeadlineExceededError struct {
+	Message string `json:"message"`
+}
+
+func (e *DeadlineExceededError) Error() string { return e.Message }
+
+// Is reports whether target is ErrDeadlineExceeded.
+func (*DeadlineExceededError) Is(target error) bool { return target == ErrDeadlineExceeded }
+
+// IsNotFound returns true if err is, wraps, or (via an Is(error) bool
+// method anywhere in its unwrap chain) resolves to ErrNotFound.
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	return os.IsNotExist(err) || errors.Is(err, ErrNotFound)
+}
+
+// IsAlreadyExists returns true if err resolves to ErrAlreadyExists.
+func IsAlreadyExists(err error) bool {
+	return errors.Is(err, ErrAlreadyExists)
+}
+
+// IsAccessDenied returns true if err resolves to ErrAccessDenied.
+func IsAccessDenied(err error) bool {
+	return errors.Is(err, ErrAccessDenied)
+}
+
+// IsBadParameter returns true if err resolves to ErrBadParameter.
+func IsBadParameter(err error) bool {
+	return errors.Is(err, ErrBadParameter)
+}
+
+// IsCompareFailed returns true if err resolves to ErrCompareFailed.
+func IsCompareFailed(err error) bool {
+	return errors.Is(err, ErrCompareFailed)
+}
+
+// IsConnectionProblem returns true if err resolves to ErrConnectionProblem.
+func IsConnectionProblem(err error) bool {
+	return errors.Is(err, ErrConnectionProblem)
+}
+
+// IsLimitExceeded returns true if err resolves to ErrLimitExceeded.
+func IsLimitExceeded(err error) bool {
+	return errors.Is(err, ErrLimitExceeded)
+}
+
+// IsNotImplemented returns true if err resolves to ErrNotImplemented.
+func IsNotImplemented(err error) bool {
+	return errors.Is(err, ErrNotImplemented)
+}
+
+// IsOAuth2 returns true if err resolves to ErrOAuth2.
+func IsOAuth2(err error) bool {
+	return errors.Is(err, ErrOAuth2)
+}
+
+// IsUnauthenticated returns true if err resolves to ErrUnauthenticated.
+func IsUnauthenticated(err error) bool {
+	return errors.Is(err, ErrUnauthenticated)
+}
+
+// IsCanceled returns true if err resolves to ErrCanceled or is a wrapped
+// context.Canceled, so that a canceled context survives the HTTP/GRPC
+// round trip instead of being reported as an internal error.
+func IsCanceled(err error) bool {
+	return errors.Is(err, ErrCanceled) || errors.Is(err, context.Canceled)
+}
+
+// IsDeadlineExceeded returns true if err resolves to ErrDeadlineExceeded
+// or is a wrapped context.DeadlineExceeded.
+func IsDeadlineExceeded(err error) bool {
+	return errors.Is(err, ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded)
+}
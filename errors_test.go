@@ -0,0 +1,34 @@
+package trace
+
+import (
+	"fmt"
+	"testing"
+)
+
+// customNotFound is a stand-in for a downstream package's own error type
+// that wants to participate in IsNotFound/ErrorToCode/trail.ToGRPC
+// without embedding *trace.NotFoundError.
+type customNotFound struct{ msg string }
+
+func (e *customNotFound) Error() string        { return e.msg }
+func (e *customNotFound) Is(target error) bool { return target == ErrNotFound }
+
+// TestIsNotFoundHonorsCustomIs checks that a wrapped error which only
+// implements Is(error) bool against ErrNotFound - not a concrete
+// *NotFoundError - is still recognized by IsNotFound.
+func TestIsNotFoundHonorsCustomIs(t *testing.T) {
+	err := fmt.Errorf("lookup failed: %w", &customNotFound{msg: "missing"})
+	if !IsNotFound(err) {
+		t.Fatal("IsNotFound did not recognize a wrapped custom Is(ErrNotFound) error")
+	}
+}
+
+// TestLookupErrorHonorsCustomIs checks that the same custom error
+// participates in the HTTP/GRPC code registry, not just the IsX helper.
+func TestLookupErrorHonorsCustomIs(t *testing.T) {
+	err := fmt.Errorf("lookup failed: %w", &customNotFound{msg: "missing"})
+	httpCode, _, ok := LookupError(err)
+	if !ok || httpCode != 404 {
+		t.Fatalf("LookupError(custom Is(ErrNotFound)) = %v, %v; want 404, true", httpCode, ok)
+	}
+}
@@ -2,57 +2,226 @@ package trace
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // WriteError sets up HTTP error response and writes it to writer w
 func WriteError(w http.ResponseWriter, err error) {
-	if !IsAggregate(err) {
-		replyJSON(w, ErrorToCode(err), err)
-		return
+	if IsAggregate(err) {
+		err = firstError(err)
 	}
+	replyJSON(w, ErrorToCode(err), err)
+}
+
+// firstError unwraps nested Aggregates and returns the first error they
+// contain, so that a single representative error can be used to pick an
+// HTTP status code (and, for ErrorWriter, a google.rpc status) for what
+// may be a collection of errors.
+func firstError(err error) error {
 	for i := 0; i < maxHops; i++ {
-		var aggErr Aggregate
-		var ok bool
-		if aggErr, ok = Unwrap(err).(Aggregate); !ok {
+		aggErr, ok := Unwrap(err).(Aggregate)
+		if !ok {
 			break
 		}
-		errors := aggErr.Errors()
-		if len(errors) == 0 {
+		errs := aggErr.Errors()
+		if len(errs) == 0 {
 			break
 		}
-		err = errors[0]
+		err = errs[0]
 	}
-	replyJSON(w, ErrorToCode(err), err)
+	return err
+}
+
+// ResponseFormat selects the JSON shape WriteError and ErrorWriter use to
+// serialize an error.
+type ResponseFormat int
+
+const (
+	// FormatTraceJSON is the historical trace format:
+	// {"error": {"message": "..."}, "traces": [...]}. It is the zero
+	// value so that an unconfigured ErrorWriter behaves like WriteError.
+	FormatTraceJSON ResponseFormat = iota
+	// FormatGoogleRPC emits a google.rpc.Status-style body:
+	// {"code": <http>, "message": "...", "status": "NOT_FOUND", "details": [...]}.
+	FormatGoogleRPC
+)
+
+// RetryAfterer is satisfied by errors that know how long a caller should
+// wait before retrying, so that ErrorWriter can attach a RetryInfo detail
+// for errors beyond the built-in LimitExceededError.
+type RetryAfterer interface {
+	RetryAfter() time.Duration
+}
+
+// defaultRetryDelay is attached to RetryInfo for errors that are
+// retryable but do not implement RetryAfterer themselves.
+const defaultRetryDelay = 30 * time.Second
+
+// errorDomain identifies this package's error taxonomy in the Domain
+// field of ErrorInfo details.
+const errorDomain = "trace.khulnasoft-lab"
+
+// ErrorWriter writes an error as an HTTP response using a configurable
+// ResponseFormat. The zero value writes the historical trace.WriteError
+// format.
+type ErrorWriter struct {
+	// Format selects the response body shape.
+	Format ResponseFormat
+}
+
+// WriteError writes err to w using ew's configured Format.
+func (ew *ErrorWriter) WriteError(w http.ResponseWriter, err error) {
+	if ew.Format == FormatGoogleRPC {
+		writeGoogleRPCError(w, err)
+		return
+	}
+	WriteError(w, err)
 }
 
-// ErrorToCode returns an appropriate HTTP status code based on the provided error type
+// httpErrorStatus returns the HTTP status code and google.rpc status name
+// for err, falling back to 500/INTERNAL when the registry has no mapping
+// for it. See RegisterErrorMapping to add to the registry.
+func httpErrorStatus(err error) (httpCode int, status string) {
+	httpCode, grpcCode, ok := LookupError(err)
+	if !ok {
+		return http.StatusInternalServerError, "INTERNAL"
+	}
+	return httpCode, CodeName(grpcCode)
+}
+
+// ErrorToCode returns an appropriate HTTP status code based on the
+// provided error type. Built-in error types are always recognized;
+// RegisterErrorMapping adds more without needing to fork this function.
 func ErrorToCode(err error) int {
-	switch {
-	case IsAggregate(err):
-		return http.StatusGatewayTimeout
-	case IsNotFound(err):
-		return http.StatusNotFound
-	case IsBadParameter(err) || IsOAuth2(err):
-		return http.StatusBadRequest
-	case IsNotImplemented(err):
-		return http.StatusNotImplemented
-	case IsCompareFailed(err):
-		return http.StatusPreconditionFailed
-	case IsAccessDenied(err):
-		return http.StatusForbidden
-	case IsAlreadyExists(err):
-		return http.StatusConflict
-	case IsLimitExceeded(err):
-		return http.StatusTooManyRequests
-	case IsConnectionProblem(err):
+	if IsAggregate(err) {
 		return http.StatusGatewayTimeout
-	default:
-		return http.StatusInternalServerError
 	}
+	code, _ := httpErrorStatus(err)
+	return code
+}
+
+// googleRPCBody is the wire shape written by ErrorWriter when its Format
+// is FormatGoogleRPC, and parsed back by ReadError.
+type googleRPCBody struct {
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Status  string            `json:"status"`
+	Details []googleRPCDetail `json:"details,omitempty"`
+}
+
+// googleRPCDetail is a single status detail, modeled after
+// google.rpc.ErrorInfo, google.rpc.RetryInfo and google.rpc.DebugInfo.
+// Only the fields relevant to the detail's @type are populated.
+type googleRPCDetail struct {
+	Type         string            `json:"@type"`
+	Reason       string            `json:"reason,omitempty"`
+	Domain       string            `json:"domain,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	RetryDelay   string            `json:"retryDelay,omitempty"`
+	StackEntries []string          `json:"stackEntries,omitempty"`
+}
+
+func writeGoogleRPCError(w http.ResponseWriter, err error) {
+	full := err
+	if IsAggregate(err) {
+		err = firstError(err)
+	}
+	code, status := httpErrorStatus(err)
+	body := googleRPCBody{
+		Code:    code,
+		Message: UserMessage(err),
+		Status:  status,
+	}
+
+	meta := map[string]string{}
+	if msg := UserMessage(err); msg != "" {
+		meta["message"] = msg
+	}
+	if traceErr, ok := err.(*TraceErr); ok {
+		for k, v := range traceErr.Fields {
+			meta[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	body.Details = append(body.Details, googleRPCDetail{
+		Type:     "type.googleapis.com/google.rpc.ErrorInfo",
+		Reason:   status,
+		Domain:   errorDomain,
+		Metadata: meta,
+	})
+
+	if delay, ok := retryDelay(err); ok {
+		body.Details = append(body.Details, googleRPCDetail{
+			Type:       "type.googleapis.com/google.rpc.RetryInfo",
+			RetryDelay: delay.String(),
+		})
+	}
+
+	if IsDebug() {
+		if entries := debugStackEntries(full); len(entries) != 0 {
+			body.Details = append(body.Details, googleRPCDetail{
+				Type:         "type.googleapis.com/google.rpc.DebugInfo",
+				StackEntries: entries,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	out, marshalErr := json.MarshalIndent(body, "", "    ")
+	if marshalErr != nil {
+		out = []byte(fmt.Sprintf(`{"code": 500, "message": "internal marshal error: %v"}`, marshalErr))
+	}
+	w.Write(out)
+}
+
+// retryDelay returns how long a caller should wait before retrying err,
+// if it is known: either because err (or something it wraps, e.g. the
+// Err of a *TraceErr) implements RetryAfterer, or because it is a
+// LimitExceededError, in which case defaultRetryDelay is used.
+func retryDelay(err error) (time.Duration, bool) {
+	var ra RetryAfterer
+	if errors.As(err, &ra) {
+		return ra.RetryAfter(), true
+	}
+	if IsLimitExceeded(err) {
+		return defaultRetryDelay, true
+	}
+	return 0, false
+}
+
+// debugStackEntries formats the Traces captured on err, if any, for use
+// as DebugInfo.stackEntries.
+func debugStackEntries(err error) []string {
+	traceErr, ok := err.(*TraceErr)
+	if !ok || len(traceErr.Traces) == 0 {
+		return nil
+	}
+	entries := make([]string, 0, len(traceErr.Traces))
+	for _, t := range traceErr.Traces {
+		entries = append(entries, fmt.Sprint(t))
+	}
+	return entries
+}
+
+// retryableError attaches an explicit retry delay - typically parsed from
+// a RetryInfo detail - to an error that does not itself implement
+// RetryAfterer.
+type retryableError struct {
+	error
+	delay time.Duration
 }
 
+// RetryAfter implements RetryAfterer.
+func (e *retryableError) RetryAfter() time.Duration { return e.delay }
+
+// Unwrap returns the wrapped error, so errors.Is/As (and IsLimitExceeded)
+// still see through a retryableError to the error it decorates.
+func (e *retryableError) Unwrap() error { return e.error }
+
 // ReadError converts http error to internal error type
 // based on HTTP response code and HTTP body contents
 // if status code does not indicate error, it will return nil
@@ -60,30 +229,83 @@ func ReadError(statusCode int, respBytes []byte) error {
 	if statusCode >= http.StatusOK && statusCode < http.StatusBadRequest {
 		return nil
 	}
+	if err, ok := readGoogleRPCError(respBytes); ok {
+		return err
+	}
 	var err error
-	switch statusCode {
-	case http.StatusNotFound:
-		err = &NotFoundError{}
-	case http.StatusBadRequest:
-		err = &BadParameterError{}
-	case http.StatusNotImplemented:
-		err = &NotImplementedError{}
-	case http.StatusPreconditionFailed:
-		err = &CompareFailedError{}
-	case http.StatusForbidden:
-		err = &AccessDeniedError{}
-	case http.StatusConflict:
-		err = &AlreadyExistsError{}
-	case http.StatusTooManyRequests:
-		err = &LimitExceededError{}
-	case http.StatusGatewayTimeout:
-		err = &ConnectionProblemError{}
-	default:
+	if factory, ok := LookupReader(statusCode); ok {
+		err = factory()
+	} else {
 		err = &RawTrace{}
 	}
 	return wrapProxy(unmarshalError(err, respBytes))
 }
 
+// readGoogleRPCError parses respBytes as the googleRPCBody shape written
+// by ErrorWriter's FormatGoogleRPC, reconstructing the TraceErr.Fields
+// from the ErrorInfo detail's metadata and, when a RetryInfo detail is
+// present, an error whose RetryAfter accessor returns the parsed delay.
+// The factory is picked via LookupReaderByStatus, not LookupReader, so
+// that body.Status (e.g. "DEADLINE_EXCEEDED") disambiguates HTTP codes
+// more than one GRPC code collapses onto - 504 is shared by Unavailable
+// and DeadlineExceeded. ok is false when respBytes is not in this shape,
+// so the caller can fall back to the legacy format.
+func readGoogleRPCError(respBytes []byte) (error, bool) {
+	var body googleRPCBody
+	if jsonErr := json.Unmarshal(respBytes, &body); jsonErr != nil || body.Status == "" {
+		return nil, false
+	}
+
+	var base error
+	if factory, ok := LookupReaderByStatus(body.Code, body.Status); ok {
+		base = SetMessage(factory(), body.Message)
+	} else {
+		base = &TraceErr{Message: body.Message}
+	}
+
+	var fields map[string]interface{}
+	var traces Traces
+	for _, d := range body.Details {
+		switch {
+		case strings.HasSuffix(d.Type, "ErrorInfo") && len(d.Metadata) != 0:
+			if fields == nil {
+				fields = make(map[string]interface{}, len(d.Metadata))
+			}
+			for k, v := range d.Metadata {
+				fields[k] = v
+			}
+		case strings.HasSuffix(d.Type, "DebugInfo"):
+			for _, entry := range d.StackEntries {
+				traces = append(traces, Trace{Func: entry})
+			}
+		case strings.HasSuffix(d.Type, "RetryInfo") && d.RetryDelay != "":
+			if delay, parseErr := time.ParseDuration(d.RetryDelay); parseErr == nil {
+				base = &retryableError{error: base, delay: delay}
+			}
+		}
+	}
+
+	if len(fields) == 0 && len(traces) == 0 {
+		return base, true
+	}
+	return &TraceErr{Err: base, Fields: fields, Traces: traces, Message: body.Message}, true
+}
+
+// SetMessage fills in the Message field of a freshly constructed error
+// via its "message" JSON tag, the same way unmarshalError fills a
+// zero-value error from a legacy response body. It is exported for
+// trail, which reconstructs errors from ErrorInfo reasons the same way.
+func SetMessage(err error, message string) error {
+	data, marshalErr := json.Marshal(struct {
+		Message string `json:"message"`
+	}{message})
+	if marshalErr != nil {
+		return err
+	}
+	_ = json.Unmarshal(data, err)
+	return err
+}
+
 func replyJSON(w http.ResponseWriter, code int, err error) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
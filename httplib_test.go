@@ -0,0 +1,34 @@
+package trace
+
+import (
+	"testing"
+	"time"
+)
+
+// customRetryable is a stand-in for a downstream RetryAfterer that does
+// not embed *LimitExceededError.
+type customRetryable struct{ delay time.Duration }
+
+func (e *customRetryable) Error() string             { return "retry me" }
+func (e *customRetryable) RetryAfter() time.Duration { return e.delay }
+
+// TestRetryDelayUnwrapsTraceErr checks that retryDelay finds a
+// RetryAfterer even when it has been wrapped in a *TraceErr, matching
+// how errors normally reach WriteError/ErrorWriter via trace.Wrap.
+func TestRetryDelayUnwrapsTraceErr(t *testing.T) {
+	wrapped := &TraceErr{Err: &customRetryable{delay: 5 * time.Second}}
+	delay, ok := retryDelay(wrapped)
+	if !ok || delay != 5*time.Second {
+		t.Fatalf("retryDelay(wrapped) = %v, %v; want 5s, true", delay, ok)
+	}
+}
+
+// TestRetryDelayFallsBackToLimitExceeded checks the pre-existing
+// default-delay behavior for a LimitExceededError that does not itself
+// implement RetryAfterer.
+func TestRetryDelayFallsBackToLimitExceeded(t *testing.T) {
+	delay, ok := retryDelay(&TraceErr{Err: &LimitExceededError{}})
+	if !ok || delay != defaultRetryDelay {
+		t.Fatalf("retryDelay(LimitExceededError) = %v, %v; want %v, true", delay, ok, defaultRetryDelay)
+	}
+}
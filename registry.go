@@ -0,0 +1,262 @@
+package trace
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+
+	"google.golang.org/grpc/codes"
+)
+
+// errorMapping associates a class of error, identified by a matcher
+// predicate, with the HTTP and GRPC codes that represent it.
+type errorMapping struct {
+	matcher  func(error) bool
+	httpCode int
+	grpcCode codes.Code
+}
+
+// readerMapping is the reverse direction, used by ReadError to pick which
+// concrete error type to reconstruct for a given HTTP status.
+type readerMapping struct {
+	httpCode int
+	factory  func() error
+}
+
+// codeReaderMapping is readerMapping's GRPC-code-keyed counterpart, used
+// by trail.FromGRPC to reconstruct a trace.*Error directly from the
+// GRPC code carried by a status, rather than detouring through the
+// collapsed HTTP status space, where e.g. Unavailable and
+// DeadlineExceeded both map to 504.
+type codeReaderMapping struct {
+	code    codes.Code
+	factory func() error
+}
+
+var (
+	registryMu         sync.RWMutex
+	errorMappings      []errorMapping
+	readerMappings     []readerMapping
+	codeReaderMappings []codeReaderMapping
+	registryBuilt      bool
+)
+
+// ensureRegistryBuilt lazily seeds the registry with the built-in
+// mappings on first use - the lazy-caching pattern devlake applies to
+// its own HTTP-code table - so that a process that never registers or
+// looks up a mapping never pays for it.
+// Callers must hold registryMu.Lock, not just RLock: this mutates
+// registryBuilt, errorMappings and readerMappings in place, and two
+// readers both observing registryBuilt == false would otherwise race
+// on the appends below.
+func ensureRegistryBuilt() {
+	if registryBuilt {
+		return
+	}
+	registryBuilt = true
+	errorMappings = append(errorMappings, builtinErrorMappings...)
+	readerMappings = append(readerMappings, builtinReaderMappings...)
+	codeReaderMappings = append(codeReaderMappings, builtinCodeReaderMappings...)
+}
+
+// RegisterErrorMapping registers a new error class: any error for which
+// matcher returns true is reported as httpCode over HTTP and grpcCode
+// over GRPC by ErrorToCode and trail.ToGRPC. The built-in mappings are
+// always consulted first, so a registered matcher cannot shadow one of
+// them; among user mappings, the first one registered whose matcher
+// matches wins.
+func RegisterErrorMapping(matcher func(error) bool, httpCode int, grpcCode codes.Code) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	ensureRegistryBuilt()
+	errorMappings = append(errorMappings, errorMapping{matcher, httpCode, grpcCode})
+}
+
+// RegisterReader registers the factory ReadError uses to reconstruct an
+// error whose HTTP response has the given status code.
+func RegisterReader(httpCode int, factory func() error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	ensureRegistryBuilt()
+	readerMappings = append(readerMappings, readerMapping{httpCode, factory})
+}
+
+// RegisterCodeReader registers the factory trail.FromGRPC uses to
+// reconstruct an error carrying the given GRPC code, for when the legacy
+// single-code mapping applies (no ErrorInfo detail on the status).
+func RegisterCodeReader(code codes.Code, factory func() error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	ensureRegistryBuilt()
+	codeReaderMappings = append(codeReaderMappings, codeReaderMapping{code, factory})
+}
+
+// LookupError returns the HTTP and GRPC codes registered for err.
+//
+// This takes the write lock, not RLock, because ensureRegistryBuilt
+// mutates the package-level slices the first time it runs; RLock would
+// let two callers race on that first build.
+func LookupError(err error) (httpCode int, grpcCode codes.Code, ok bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	ensureRegistryBuilt()
+	for _, m := range errorMappings {
+		if m.matcher(err) {
+			return m.httpCode, m.grpcCode, true
+		}
+	}
+	return 0, codes.Unknown, false
+}
+
+// LookupReader returns the factory registered for httpCode.
+//
+// Like LookupError, this takes the write lock for ensureRegistryBuilt's
+// sake rather than racing two RLock holders on the first build.
+func LookupReader(httpCode int) (factory func() error, ok bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	ensureRegistryBuilt()
+	for _, r := range readerMappings {
+		if r.httpCode == httpCode {
+			return r.factory, true
+		}
+	}
+	return nil, false
+}
+
+// LookupReaderByCode returns the factory registered for grpcCode, for
+// trail.FromGRPC's legacy (no ErrorInfo detail) reconstruction path. It
+// is keyed on the GRPC code itself rather than the HTTP status grpcCode
+// would collapse to via LookupHTTPStatusForCode, so e.g. Unavailable and
+// DeadlineExceeded - which both map to the same HTTP 504 - still
+// reconstruct distinct error types.
+func LookupReaderByCode(grpcCode codes.Code) (factory func() error, ok bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	ensureRegistryBuilt()
+	for _, r := range codeReaderMappings {
+		if r.code == grpcCode {
+			return r.factory, true
+		}
+	}
+	return nil, false
+}
+
+// LookupHTTPStatusForCode returns the HTTP status registered alongside
+// grpcCode, for trail.CodeToHTTPStatus, so that the GRPC-to-HTTP mapping
+// has a single source of truth instead of being hand-duplicated in trail.
+func LookupHTTPStatusForCode(grpcCode codes.Code) (httpCode int, ok bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	ensureRegistryBuilt()
+	for _, m := range errorMappings {
+		if m.grpcCode == grpcCode {
+			return m.httpCode, true
+		}
+	}
+	return 0, false
+}
+
+// CodeName returns the upper-snake-case form of a GRPC code's name, e.g.
+// codes.NotFound -> "NOT_FOUND". It is used as the reason/status string
+// in ErrorInfo details and google.rpc-style JSON bodies, so that both are
+// derived from the single registry rather than hand-maintained in sync.
+func CodeName(code codes.Code) string {
+	s := code.String()
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// maxKnownCode is the highest GRPC code CodeFromName searches; it must be
+// kept in step with the highest code codes.Code defines (codes.Unauthenticated).
+const maxKnownCode = codes.Unauthenticated
+
+// CodeFromName is the inverse of CodeName: it returns the GRPC code whose
+// CodeName is name, or ok=false if none matches. It lets a reader that
+// only has an HTTP status and a status string (as in the google.rpc JSON
+// format ErrorWriter writes) recover the exact GRPC code, the same way a
+// GRPC client already can from a status.Status.
+func CodeFromName(name string) (code codes.Code, ok bool) {
+	for c := codes.Code(0); c <= maxKnownCode; c++ {
+		if CodeName(c) == name {
+			return c, true
+		}
+	}
+	return codes.Unknown, false
+}
+
+// LookupReaderByStatus returns the factory for reconstructing an error
+// read over plain HTTP, preferring status (e.g. "DEADLINE_EXCEEDED", as
+// written in the google.rpc JSON body's "status" field) to disambiguate
+// HTTP codes more than one GRPC code collapses onto - 504 is shared by
+// Unavailable and DeadlineExceeded - before falling back to LookupReader.
+func LookupReaderByStatus(httpCode int, status string) (factory func() error, ok bool) {
+	if status != "" {
+		if code, ok := CodeFromName(status); ok {
+			if factory, ok := LookupReaderByCode(code); ok {
+				return factory, true
+			}
+		}
+	}
+	return LookupReader(httpCode)
+}
+
+// clientClosedRequest is nginx's de facto HTTP status for a canceled
+// request; net/http has no named constant for it.
+const clientClosedRequest = 499
+
+var builtinErrorMappings = []errorMapping{
+	{IsNotFound, http.StatusNotFound, codes.NotFound},
+	{IsBadParameter, http.StatusBadRequest, codes.InvalidArgument},
+	{IsOAuth2, http.StatusBadRequest, codes.InvalidArgument},
+	{IsNotImplemented, http.StatusNotImplemented, codes.Unimplemented},
+	{IsCompareFailed, http.StatusPreconditionFailed, codes.FailedPrecondition},
+	{IsAccessDenied, http.StatusForbidden, codes.PermissionDenied},
+	{IsAlreadyExists, http.StatusConflict, codes.AlreadyExists},
+	{IsLimitExceeded, http.StatusTooManyRequests, codes.ResourceExhausted},
+	{IsConnectionProblem, http.StatusGatewayTimeout, codes.Unavailable},
+	{IsUnauthenticated, http.StatusUnauthorized, codes.Unauthenticated},
+	{IsCanceled, clientClosedRequest, codes.Canceled},
+	{IsDeadlineExceeded, http.StatusGatewayTimeout, codes.DeadlineExceeded},
+}
+
+var builtinReaderMappings = []readerMapping{
+	{http.StatusNotFound, func() error { return &NotFoundError{} }},
+	{http.StatusBadRequest, func() error { return &BadParameterError{} }},
+	{http.StatusNotImplemented, func() error { return &NotImplementedError{} }},
+	{http.StatusPreconditionFailed, func() error { return &CompareFailedError{} }},
+	{http.StatusForbidden, func() error { return &AccessDeniedError{} }},
+	{http.StatusConflict, func() error { return &AlreadyExistsError{} }},
+	{http.StatusTooManyRequests, func() error { return &LimitExceededError{} }},
+	{http.StatusGatewayTimeout, func() error { return &ConnectionProblemError{} }},
+	{http.StatusUnauthorized, func() error { return &UnauthenticatedError{} }},
+	// clientClosedRequest is not shared with any other builtin mapping, so
+	// it round-trips unambiguously even through the collapsed HTTP status.
+	{clientClosedRequest, func() error { return &CanceledError{} }},
+}
+
+// builtinCodeReaderMappings is trail.FromGRPC's legacy reconstruction
+// table, keyed directly on the GRPC code rather than the HTTP status it
+// maps to. codes.Unavailable and codes.DeadlineExceeded both collapse to
+// HTTP 504 (see builtinErrorMappings), so reconstructing from the GRPC
+// code - which trail always has - is the only way to tell them apart.
+var builtinCodeReaderMappings = []codeReaderMapping{
+	{codes.NotFound, func() error { return &NotFoundError{} }},
+	{codes.InvalidArgument, func() error { return &BadParameterError{} }},
+	{codes.Unimplemented, func() error { return &NotImplementedError{} }},
+	{codes.FailedPrecondition, func() error { return &CompareFailedError{} }},
+	{codes.PermissionDenied, func() error { return &AccessDeniedError{} }},
+	{codes.AlreadyExists, func() error { return &AlreadyExistsError{} }},
+	{codes.ResourceExhausted, func() error { return &LimitExceededError{} }},
+	{codes.Unavailable, func() error { return &ConnectionProblemError{} }},
+	{codes.Unauthenticated, func() error { return &UnauthenticatedError{} }},
+	{codes.Canceled, func() error { return &CanceledError{} }},
+	{codes.DeadlineExceeded, func() error { return &DeadlineExceededError{} }},
+}
@@ -0,0 +1,129 @@
+package trace
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+// TestLookupErrorConcurrent exercises the lazy-build path of the
+// registry from many goroutines before anything has forced a build, so
+// that `go test -race` catches a regression back to RLock in
+// ensureRegistryBuilt's callers.
+func TestLookupErrorConcurrent(t *testing.T) {
+	registryMu.Lock()
+	registryBuilt = false
+	errorMappings, readerMappings, codeReaderMappings = nil, nil, nil
+	registryMu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			LookupError(&NotFoundError{})
+			LookupReader(http.StatusNotFound)
+			LookupReaderByCode(codes.NotFound)
+		}()
+	}
+	wg.Wait()
+}
+
+// TestLookupReaderByCodeDisambiguatesDeadlineExceeded checks that
+// codes.Unavailable and codes.DeadlineExceeded, which collapse to the
+// same HTTP 504 status, still reconstruct distinct error types when
+// looked up by GRPC code rather than by HTTP status.
+func TestLookupReaderByCodeDisambiguatesDeadlineExceeded(t *testing.T) {
+	unavailable, ok := LookupReaderByCode(codes.Unavailable)
+	if !ok {
+		t.Fatal("expected a reader for codes.Unavailable")
+	}
+	if _, ok := unavailable().(*ConnectionProblemError); !ok {
+		t.Fatalf("codes.Unavailable reconstructed as %T, want *ConnectionProblemError", unavailable())
+	}
+
+	deadlineExceeded, ok := LookupReaderByCode(codes.DeadlineExceeded)
+	if !ok {
+		t.Fatal("expected a reader for codes.DeadlineExceeded")
+	}
+	if _, ok := deadlineExceeded().(*DeadlineExceededError); !ok {
+		t.Fatalf("codes.DeadlineExceeded reconstructed as %T, want *DeadlineExceededError", deadlineExceeded())
+	}
+}
+
+// TestLookupReaderHandlesUnauthenticatedAndCanceled checks the two HTTP
+// statuses that previously had no reader entry and fell through to a
+// bare *RawTrace.
+func TestLookupReaderHandlesUnauthenticatedAndCanceled(t *testing.T) {
+	unauthenticated, ok := LookupReader(http.StatusUnauthorized)
+	if !ok {
+		t.Fatal("expected a reader for 401")
+	}
+	if _, ok := unauthenticated().(*UnauthenticatedError); !ok {
+		t.Fatalf("LookupReader(401) reconstructed as %T, want *UnauthenticatedError", unauthenticated())
+	}
+
+	canceled, ok := LookupReader(clientClosedRequest)
+	if !ok {
+		t.Fatal("expected a reader for 499")
+	}
+	if _, ok := canceled().(*CanceledError); !ok {
+		t.Fatalf("LookupReader(499) reconstructed as %T, want *CanceledError", canceled())
+	}
+}
+
+// TestReaderFactoriesProduceFreshMessageableValues checks that the
+// factories for the new categories return a fresh struct with an
+// exported Message field - not the package-level sentinel error, which
+// has no exported field for SetMessage/json.Unmarshal to write into and
+// would silently drop the server's message on every round trip.
+func TestReaderFactoriesProduceFreshMessageableValues(t *testing.T) {
+	unauthenticated, _ := LookupReader(http.StatusUnauthorized)
+	if got := SetMessage(unauthenticated(), "nope").(*UnauthenticatedError).Message; got != "nope" {
+		t.Fatalf("UnauthenticatedError.Message = %q, want %q", got, "nope")
+	}
+
+	canceled, _ := LookupReader(clientClosedRequest)
+	if got := SetMessage(canceled(), "stopped").(*CanceledError).Message; got != "stopped" {
+		t.Fatalf("CanceledError.Message = %q, want %q", got, "stopped")
+	}
+
+	deadlineExceeded, _ := LookupReaderByCode(codes.DeadlineExceeded)
+	if got := SetMessage(deadlineExceeded(), "too slow").(*DeadlineExceededError).Message; got != "too slow" {
+		t.Fatalf("DeadlineExceededError.Message = %q, want %q", got, "too slow")
+	}
+}
+
+// TestLookupReaderByStatusDisambiguatesDeadlineExceeded checks that the
+// plain-HTTP reader path also tells DeadlineExceeded and ConnectionProblem
+// apart, using the google.rpc status string, even though both share HTTP
+// 504.
+func TestLookupReaderByStatusDisambiguatesDeadlineExceeded(t *testing.T) {
+	factory, ok := LookupReaderByStatus(http.StatusGatewayTimeout, CodeName(codes.DeadlineExceeded))
+	if !ok {
+		t.Fatal("expected a reader for 504/DEADLINE_EXCEEDED")
+	}
+	if _, ok := factory().(*DeadlineExceededError); !ok {
+		t.Fatalf("LookupReaderByStatus(504, DEADLINE_EXCEEDED) reconstructed as %T, want *DeadlineExceededError", factory())
+	}
+
+	factory, ok = LookupReaderByStatus(http.StatusGatewayTimeout, CodeName(codes.Unavailable))
+	if !ok {
+		t.Fatal("expected a reader for 504/UNAVAILABLE")
+	}
+	if _, ok := factory().(*ConnectionProblemError); !ok {
+		t.Fatalf("LookupReaderByStatus(504, UNAVAILABLE) reconstructed as %T, want *ConnectionProblemError", factory())
+	}
+
+	// No status string: falls back to the HTTP-only registry, same as
+	// before this request.
+	factory, ok = LookupReaderByStatus(http.StatusGatewayTimeout, "")
+	if !ok {
+		t.Fatal("expected a reader for bare 504")
+	}
+	if _, ok := factory().(*ConnectionProblemError); !ok {
+		t.Fatalf("LookupReaderByStatus(504, \"\") reconstructed as %T, want *ConnectionProblemError", factory())
+	}
+}
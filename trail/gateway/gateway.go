@@ -0,0 +1,49 @@
+// Package gateway wires trail's GRPC error handling into grpc-gateway. It
+// is a separate module so that the core trace module does not take a
+// hard dependency on grpc-gateway.
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+
+	"github.com/khulnasoft-lab/trace"
+	"github.com/khulnasoft-lab/trace/trail"
+)
+
+// GatewayErrorHandler is a runtime.ErrorHandlerFunc for a grpc-gateway mux
+// fronting a service that reports errors via trail.Send. It decodes the
+// ErrorInfo/DebugInfo/RetryInfo details trail.ToGRPC attaches back into a
+// trace.Error and writes it with trace.WriteError, so the HTTP status and
+// body shape are those trace.WriteError gives the reconstructed error.
+// The body is not byte-identical to what the service would have written
+// directly: stack traces come back from DebugInfo as formatted entries
+// without their Path and Line.
+func GatewayErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	statusErr := status.Convert(err)
+	traceErr := trail.FromGRPC(err)
+
+	setHeaders(w, statusErr, traceErr)
+	trace.WriteError(w, traceErr)
+}
+
+// setHeaders sets the HTTP headers that trace.WriteError itself has no
+// way to know about: Retry-After from a RetryInfo detail, and
+// WWW-Authenticate for access-denied errors.
+func setHeaders(w http.ResponseWriter, statusErr *status.Status, traceErr error) {
+	if trace.IsAccessDenied(traceErr) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="trace"`)
+	}
+	for _, detail := range statusErr.Details() {
+		retryInfo, ok := detail.(*errdetails.RetryInfo)
+		if !ok || retryInfo.RetryDelay == nil {
+			continue
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryInfo.RetryDelay.AsDuration().Seconds())))
+	}
+}
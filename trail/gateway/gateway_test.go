@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/khulnasoft-lab/trace"
+)
+
+// TestSetHeadersRetryAfter checks that a RetryInfo detail on the GRPC
+// status becomes a Retry-After header on the HTTP response.
+func TestSetHeadersRetryAfter(t *testing.T) {
+	st, err := status.New(codes.ResourceExhausted, "too many requests").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(30 * time.Second),
+	})
+	if err != nil {
+		t.Fatalf("building status: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	setHeaders(w, st, &trace.LimitExceededError{})
+
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Fatalf("Retry-After header = %q, want %q", got, "30")
+	}
+}
+
+// TestSetHeadersAccessDenied checks that an access-denied error gets a
+// WWW-Authenticate challenge.
+func TestSetHeadersAccessDenied(t *testing.T) {
+	st := status.New(codes.PermissionDenied, "nope")
+
+	w := httptest.NewRecorder()
+	setHeaders(w, st, &trace.AccessDeniedError{})
+
+	if got := w.Header().Get("WWW-Authenticate"); got == "" {
+		t.Fatal("expected a WWW-Authenticate header for an access-denied error")
+	}
+}
@@ -3,14 +3,19 @@ package trail
 import (
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
-	"os"
+	"net/http"
+	"time"
 
 	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/khulnasoft-lab/trace"
 	"github.com/khulnasoft-lab/trace/internal"
@@ -38,21 +43,108 @@ func Send(ctx context.Context, err error) error {
 }
 
 // DebugReportMetadata is a key in metadata holding debug information
-// about the error - stack traces and original error
+// about the error - stack traces and original error.
+//
+// Deprecated: ToGRPC now attaches the same information as a DebugInfo
+// detail on the returned status, which survives proxies that strip
+// metadata. DebugReportMetadata is still populated by Send and honored by
+// FromGRPC so that older peers keep working.
 const DebugReportMetadata = "trace-debug-report"
 
-// ToGRPC converts error to GRPC-compatible error
+// errorDomain identifies this package's error taxonomy in the Domain field
+// of ErrorInfo details, so that peers can distinguish a trace.khulnasoft-lab
+// reason from another package's error that happens to use the same string.
+const errorDomain = "trace.khulnasoft-lab"
+
+// defaultRetryDelay is reported via RetryInfo for errors that are known to
+// be retryable but do not carry a delay of their own.
+const defaultRetryDelay = 30 * time.Second
+
+// knownCodes are the GRPC codes trail knows how to turn back into a
+// trace.*Error, in the order they should be tried when reversing a
+// CodeName string back to a code.
+var knownCodes = []codes.Code{
+	codes.PermissionDenied,
+	codes.AlreadyExists,
+	codes.InvalidArgument,
+	codes.FailedPrecondition,
+	codes.Unavailable,
+	codes.ResourceExhausted,
+	codes.NotFound,
+	codes.Unimplemented,
+	codes.Unauthenticated,
+	codes.Canceled,
+	codes.DeadlineExceeded,
+}
+
+// reasonForError returns the GRPC code and ErrorInfo reason for err,
+// consulting trace's single error registry (see trace.RegisterErrorMapping),
+// or ok=false if it has no mapping for err.
+func reasonForError(err error) (code codes.Code, reason string, ok bool) {
+	_, grpcCode, ok := trace.LookupError(err)
+	if !ok {
+		return codes.Unknown, "", false
+	}
+	return grpcCode, trace.CodeName(grpcCode), true
+}
+
+// errorForReason is the inverse of reasonForError: it maps an
+// ErrorInfo.Reason back to one of knownCodes and reconstructs the
+// trace.*Error the registry associates with that GRPC code. It looks the
+// factory up by code directly, rather than via CodeToHTTPStatus, because
+// some codes (Unavailable and DeadlineExceeded, notably) collapse to the
+// same HTTP status and would otherwise reconstruct as the wrong type.
+func errorForReason(reason, message string) error {
+	for _, code := range knownCodes {
+		if trace.CodeName(code) != reason {
+			continue
+		}
+		factory, ok := trace.LookupReaderByCode(code)
+		if !ok {
+			return nil
+		}
+		return trace.SetMessage(factory(), message)
+	}
+	return nil
+}
+
+// isRetryable reports whether err should carry a RetryInfo detail.
+func isRetryable(err error) bool {
+	return trace.IsLimitExceeded(err) || trace.IsConnectionProblem(err)
+}
+
+// CodeToHTTPStatus maps a GRPC code to the HTTP status trace.ErrorToCode
+// would produce for the error that code was derived from. It is exported
+// for packages that front a GRPC service with HTTP and need the two to
+// agree. It consults trace's single error
+// registry rather than hand-duplicating the GRPC-to-HTTP table here, so
+// the two can never drift.
+func CodeToHTTPStatus(code codes.Code) int {
+	if httpCode, ok := trace.LookupHTTPStatusForCode(code); ok {
+		return httpCode
+	}
+	return http.StatusInternalServerError
+}
+
+// ToGRPC converts error to a GRPC-compatible error. The full error
+// structure - every error of an Aggregate, the captured stack traces and
+// retry information - is preserved as status.Details on the returned
+// error, so that FromGRPC can reconstruct it on the other end, even
+// through proxies that do not forward metadata. This is the same
+// technique containerd's errdefs/errgrpc package uses to make GRPC a
+// lossless transport for a rich error type. Errors that trace's registry
+// has no mapping for get no ErrorInfo detail, so FromGRPC does not
+// reconstruct them.
 func ToGRPC(originalErr error) error {
 	if originalErr == nil {
 		return nil
 	}
 
-	// Avoid modifying top-level gRPC errors.
+	// Avoid modifying top-level GRPC errors.
 	if _, ok := status.FromError(originalErr); ok {
 		return originalErr
 	}
 
-	code := codes.Unknown
 	returnOriginal := false
 	internal.TraverseErr(originalErr, func(err error) (ok bool) {
 		if err == io.EOF {
@@ -60,103 +152,239 @@ func ToGRPC(originalErr error) error {
 			returnOriginal = true
 			return true
 		}
+		_, ok = status.FromError(err)
+		return ok
+	})
+	if returnOriginal {
+		return originalErr
+	}
 
-		if s, ok := status.FromError(err); ok {
-			code = s.Code()
-			return true
+	errs := []error{originalErr}
+	if agg, ok := trace.Unwrap(originalErr).(trace.Aggregate); ok {
+		if aggErrs := agg.Errors(); len(aggErrs) != 0 {
+			errs = aggErrs
 		}
+	}
 
-		// Duplicate check from trace.IsNotFound.
-		if os.IsNotExist(err) {
-			code = codes.NotFound
-			return true
+	code := codes.Unknown
+	var details []proto.Message
+	for _, err := range errs {
+		c, reason, ok := reasonForError(err)
+		if !ok {
+			continue
+		}
+		if code == codes.Unknown {
+			code = c
 		}
+		details = append(details, &errdetails.ErrorInfo{
+			Reason:   reason,
+			Domain:   errorDomain,
+			Metadata: errorMetadata(err),
+		})
+		if isRetryable(err) {
+			details = append(details, &errdetails.RetryInfo{
+				RetryDelay: durationpb.New(defaultRetryDelay),
+			})
+		}
+	}
+	if traces := traceErrTraces(originalErr); len(traces) != 0 {
+		details = append(details, &errdetails.DebugInfo{
+			StackEntries: traces,
+		})
+	}
 
-		ok = true // Assume match
-		switch err.(type) {
-		case *trace.AccessDeniedError:
-			code = codes.PermissionDenied
-		case *trace.AlreadyExistsError:
-			code = codes.AlreadyExists
-		case *trace.BadParameterError:
-			code = codes.InvalidArgument
-		case *trace.CompareFailedError:
-			code = codes.FailedPrecondition
-		case *trace.ConnectionProblemError:
-			code = codes.Unavailable
-		case *trace.LimitExceededError:
-			code = codes.ResourceExhausted
-		case *trace.NotFoundError:
-			code = codes.NotFound
-		case *trace.NotImplementedError:
-			code = codes.Unimplemented
-		case *trace.OAuth2Error:
-			code = codes.InvalidArgument
-		// *trace.RetryError not mapped.
-		// *trace.TrustError not mapped.
-		default:
-			ok = false
+	st, err := status.New(code, trace.UserMessage(originalErr)).WithDetails(details...)
+	if err != nil {
+		// Fall back to a plain status rather than losing the error entirely.
+		return status.Error(code, trace.UserMessage(originalErr))
+	}
+	return st.Err()
+}
+
+// errorMetadata builds the ErrorInfo.Metadata for err: the underlying Go
+// type name, the user-facing message and, if err is wrapped in a
+// *trace.TraceErr, its Fields.
+func errorMetadata(err error) map[string]string {
+	meta := map[string]string{
+		"type": fmt.Sprintf("%T", trace.Unwrap(err)),
+	}
+	if msg := trace.UserMessage(err); msg != "" {
+		meta["message"] = msg
+	}
+	if traceErr, ok := err.(*trace.TraceErr); ok {
+		for k, v := range traceErr.Fields {
+			meta[k] = fmt.Sprintf("%v", v)
 		}
-		return ok
-	})
-	if returnOriginal {
-		return originalErr
 	}
+	return meta
+}
 
-	return status.Error(code, trace.UserMessage(originalErr))
+// traceErrTraces returns the captured stack traces of err formatted as
+// human-readable strings, for use as DebugInfo.StackEntries.
+func traceErrTraces(err error) []string {
+	traceErr, ok := err.(*trace.TraceErr)
+	if !ok || len(traceErr.Traces) == 0 {
+		return nil
+	}
+	entries := make([]string, 0, len(traceErr.Traces))
+	for _, t := range traceErr.Traces {
+		entries = append(entries, fmt.Sprint(t))
+	}
+	return entries
 }
 
-// FromGRPC converts error from GRPC error back to trace.Error
-// Debug information will be retrieved from the metadata if specified in args
+// FromGRPC converts an error from a GRPC error back to a trace.Error.
+// It first reconstructs the error from the status.Details attached by
+// ToGRPC, so it works through proxies that strip metadata; debug
+// information will additionally be retrieved from the metadata if
+// specified in args, for interoperability with older peers.
 func FromGRPC(err error, args ...interface{}) error {
 	if err == nil {
 		return nil
 	}
 
 	statusErr := status.Convert(err)
-	code := statusErr.Code()
-	message := statusErr.Message()
+	if statusErr.Code() == codes.OK {
+		return nil
+	}
+
+	e := errorFromDetails(statusErr)
+
+	if len(args) != 0 {
+		if meta, ok := args[0].(metadata.MD); ok {
+			decoded := DecodeDebugInfo(e, meta)
+			// We return here because if it's a trace.Error then
+			// frames was already extracted from metadata so
+			// there's no need to capture frames once again.
+			if _, ok := decoded.(trace.Error); ok {
+				return decoded
+			}
+			e = decoded
+		}
+	}
+	if _, ok := e.(trace.Error); ok {
+		return e
+	}
+	traces := internal.CaptureTraces(1)
+	return &trace.TraceErr{Err: e, Traces: traces}
+}
+
+// retryableError attaches a RetryInfo detail's delay to a reconstructed
+// error that does not itself implement trace.RetryAfterer, mirroring the
+// unexported equivalent trace's own HTTP transport uses for the same
+// purpose.
+type retryableError struct {
+	error
+	delay time.Duration
+}
+
+// RetryAfter implements trace.RetryAfterer.
+func (e *retryableError) RetryAfter() time.Duration { return e.delay }
+
+// Unwrap returns the wrapped error, so errors.Is/As still see through a
+// retryableError to the error it decorates.
+func (e *retryableError) Unwrap() error { return e.error }
+
+// errorFromDetails reconstructs an error from the ErrorInfo, DebugInfo and
+// RetryInfo details of statusErr, falling back to the legacy single-code
+// mapping when no ErrorInfo detail is present (e.g. the peer is running an
+// older version of this package).
+func errorFromDetails(statusErr *status.Status) error {
+	var errs []error
+	var fields map[string]interface{}
+	var traces trace.Traces
+	var retryDelay time.Duration
+	var hasRetryDelay bool
+	for _, detail := range statusErr.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			if d.Domain != errorDomain {
+				continue
+			}
+			message := d.Metadata["message"]
+			if message == "" {
+				message = statusErr.Message()
+			}
+			if e := errorForReason(d.Reason, message); e != nil {
+				errs = append(errs, e)
+				for k, v := range d.Metadata {
+					if k == "type" || k == "message" {
+						continue
+					}
+					if fields == nil {
+						fields = make(map[string]interface{}, len(d.Metadata))
+					}
+					fields[k] = v
+				}
+			}
+		case *errdetails.DebugInfo:
+			for _, entry := range d.StackEntries {
+				// Best-effort reconstruction: the original Path/Line are
+				// not recoverable from the formatted string, so the whole
+				// entry is kept as the trace's Func.
+				traces = append(traces, trace.Trace{Func: entry})
+			}
+		case *errdetails.RetryInfo:
+			if d.RetryDelay != nil {
+				retryDelay = d.RetryDelay.AsDuration()
+				hasRetryDelay = true
+			}
+		}
+	}
 
 	var e error
+	switch len(errs) {
+	case 0:
+		e = legacyErrorFromCode(statusErr.Code(), statusErr.Message())
+	case 1:
+		e = errs[0]
+	default:
+		e = trace.NewAggregate(errs...)
+	}
+	if hasRetryDelay {
+		e = &retryableError{error: e, delay: retryDelay}
+	}
+
+	if len(fields) == 0 && len(traces) == 0 {
+		return e
+	}
+	return &trace.TraceErr{Err: e, Fields: fields, Traces: traces, Message: statusErr.Message()}
+}
+
+// legacyErrorFromCode reconstructs a trace.*Error from a bare GRPC code,
+// for peers that do not yet attach ErrorInfo details.
+func legacyErrorFromCode(code codes.Code, message string) error {
 	switch code {
-	case codes.OK:
-		return nil
 	case codes.NotFound:
-		e = &trace.NotFoundError{Message: message}
+		return &trace.NotFoundError{Message: message}
 	case codes.AlreadyExists:
-		e = &trace.AlreadyExistsError{Message: message}
+		return &trace.AlreadyExistsError{Message: message}
 	case codes.PermissionDenied:
-		e = &trace.AccessDeniedError{Message: message}
+		return &trace.AccessDeniedError{Message: message}
 	case codes.FailedPrecondition:
-		e = &trace.CompareFailedError{Message: message}
+		return &trace.CompareFailedError{Message: message}
 	case codes.InvalidArgument:
-		e = &trace.BadParameterError{Message: message}
+		return &trace.BadParameterError{Message: message}
 	case codes.ResourceExhausted:
-		e = &trace.LimitExceededError{Message: message}
+		return &trace.LimitExceededError{Message: message}
 	case codes.Unavailable:
-		e = &trace.ConnectionProblemError{Message: message}
+		return &trace.ConnectionProblemError{Message: message}
 	case codes.Unimplemented:
-		e = &trace.NotImplementedError{Message: message}
+		return &trace.NotImplementedError{Message: message}
 	default:
-		e = err
-	}
-	if len(args) != 0 {
-		if meta, ok := args[0].(metadata.MD); ok {
-			e = DecodeDebugInfo(e, meta)
-			// We return here because if it's a trace.Error then
-			// frames was already extracted from metadata so
-			// there's no need to capture frames once again.
-			if _, ok := e.(trace.Error); ok {
-				return e
-			}
+		if factory, ok := trace.LookupReaderByCode(code); ok {
+			return trace.SetMessage(factory(), message)
 		}
+		return status.New(code, message).Err()
 	}
-	traces := internal.CaptureTraces(1)
-	return &trace.TraceErr{Err: e, Traces: traces}
 }
 
 // SetDebugInfo adds debug metadata about error (traces, original error)
-// to request metadata as encoded property
+// to request metadata as encoded property.
+//
+// Deprecated: ToGRPC attaches the same information as a DebugInfo detail
+// on the status itself. SetDebugInfo is still called by Send for
+// interoperability with peers that have not upgraded to the new FromGRPC.
 func SetDebugInfo(err error, meta metadata.MD) {
 	if _, ok := err.(*trace.TraceErr); !ok {
 		return
@@ -0,0 +1,62 @@
+package trail
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/khulnasoft-lab/trace"
+)
+
+// TestToGRPCFromGRPCPreservesAggregateFields checks that a ToGRPC/FromGRPC
+// round trip keeps the Fields of every member of an Aggregate, not just
+// the last one reconstructed from the status's ErrorInfo details.
+func TestToGRPCFromGRPCPreservesAggregateFields(t *testing.T) {
+	first := &trace.TraceErr{
+		Err:    &trace.NotFoundError{Message: "first"},
+		Fields: map[string]interface{}{"a": "1"},
+	}
+	second := &trace.TraceErr{
+		Err:    &trace.AlreadyExistsError{Message: "second"},
+		Fields: map[string]interface{}{"b": "2"},
+	}
+
+	restored := FromGRPC(ToGRPC(trace.NewAggregate(first, second)))
+
+	traceErr, ok := restored.(*trace.TraceErr)
+	if !ok {
+		t.Fatalf("FromGRPC returned %T, want *trace.TraceErr", restored)
+	}
+	if traceErr.Fields["a"] != "1" || traceErr.Fields["b"] != "2" {
+		t.Fatalf("Fields = %v, want both \"a\" and \"b\" present", traceErr.Fields)
+	}
+}
+
+// TestToGRPCFromGRPCRoundTripsSingleError checks the non-aggregate path:
+// a single trace.*Error still comes back as the same concrete type with
+// its message intact.
+func TestToGRPCFromGRPCRoundTripsSingleError(t *testing.T) {
+	restored := FromGRPC(ToGRPC(&trace.NotFoundError{Message: "gone"}))
+
+	notFound, ok := restored.(*trace.NotFoundError)
+	if !ok {
+		t.Fatalf("FromGRPC returned %T, want *trace.NotFoundError", restored)
+	}
+	if notFound.Message != "gone" {
+		t.Fatalf("Message = %q, want %q", notFound.Message, "gone")
+	}
+}
+
+// TestToGRPCFromGRPCPreservesRetryInfo checks that the RetryInfo detail
+// ToGRPC attaches for a retryable error survives the round trip: the
+// error FromGRPC returns must still implement trace.RetryAfterer.
+func TestToGRPCFromGRPCPreservesRetryInfo(t *testing.T) {
+	restored := FromGRPC(ToGRPC(&trace.LimitExceededError{Message: "slow down"}))
+
+	var ra trace.RetryAfterer
+	if !errors.As(restored, &ra) {
+		t.Fatalf("FromGRPC result %T does not implement trace.RetryAfterer", restored)
+	}
+	if ra.RetryAfter() != defaultRetryDelay {
+		t.Fatalf("RetryAfter() = %v, want %v", ra.RetryAfter(), defaultRetryDelay)
+	}
+}